@@ -0,0 +1,415 @@
+package messaging
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Event is the local, in-process representation of a decoded
+// subscribeMessage. It mirrors the wire fields under friendly names so
+// that query predicates don't need to know about the single-letter JSON
+// tags used on the wire.
+type Event struct {
+	Channel           string
+	SubscriptionMatch string
+	Shard             string
+	Payload           interface{}
+	Flags             int
+	IssuingClientID   string
+	SequenceNumber    uint64
+	OriginatingTT     string
+	PublishTT         string
+	UserMetadata      interface{}
+}
+
+func eventFromMessage(msg *subscribeMessage) Event {
+	return Event{
+		Channel:           msg.Channel,
+		SubscriptionMatch: msg.SubscriptionMatch,
+		Shard:             msg.Shard,
+		Payload:           msg.Payload,
+		Flags:             msg.Flags,
+		IssuingClientID:   msg.IssuingClientId,
+		SequenceNumber:    msg.SequenceNumber,
+		OriginatingTT:     msg.OriginatingTimetoken.Timetoken,
+		PublishTT:         msg.PublishTimetokenMetadata.Timetoken,
+		UserMetadata:      msg.UserMetadata,
+	}
+}
+
+// field looks up the value of a query field name on the event. Field
+// names match the lower_snake_case spelling of the Event members, except
+// that SequenceNumber is queried as "sequence". Payload and UserMetadata
+// are not queryable.
+func (e Event) field(name string) (interface{}, bool) {
+	switch name {
+	case "channel":
+		return e.Channel, true
+	case "subscription_match":
+		return e.SubscriptionMatch, true
+	case "shard":
+		return e.Shard, true
+	case "flags":
+		return e.Flags, true
+	case "issuing_client_id":
+		return e.IssuingClientID, true
+	case "sequence":
+		return e.SequenceNumber, true
+	case "originating_tt":
+		return e.OriginatingTT, true
+	case "publish_tt":
+		return e.PublishTT, true
+	default:
+		return nil, false
+	}
+}
+
+type eventSubscriber struct {
+	id      string
+	query   *eventQuery
+	out     chan Event
+	dropped uint64
+}
+
+// EventBus is a local, in-process pub/sub layer that mirrors the remote
+// PubNub subscribe loop: application code can subscribe to events by a
+// simple query predicate without ever going out over the wire, which is
+// handy for testing and for composing local consumers.
+type EventBus struct {
+	sync.RWMutex
+	subscribers map[string]*eventSubscriber
+}
+
+// NewEventBus creates an empty EventBus.
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subscribers: make(map[string]*eventSubscriber),
+	}
+}
+
+// Subscribe registers a new subscriber whose query is evaluated against
+// every published Event. Matching events are copied onto the returned
+// channel, which is buffered to capacity; a subscriber that falls behind
+// has events dropped (and counted, see Dropped) rather than blocking
+// Publish.
+func (b *EventBus) Subscribe(query string, capacity int) (id string, out <-chan Event, err error) {
+	if capacity < 0 {
+		return "", nil, fmt.Errorf("messaging: negative subscribe capacity %d", capacity)
+	}
+
+	q, err := parseEventQuery(query)
+	if err != nil {
+		return "", nil, err
+	}
+
+	sub := &eventSubscriber{
+		id:    newSubscriptionID(),
+		query: q,
+		out:   make(chan Event, capacity),
+	}
+
+	b.Lock()
+	b.subscribers[sub.id] = sub
+	b.Unlock()
+
+	return sub.id, sub.out, nil
+}
+
+// Unsubscribe removes a subscriber so it no longer receives events.
+func (b *EventBus) Unsubscribe(id string) bool {
+	b.Lock()
+	defer b.Unlock()
+
+	if _, ok := b.subscribers[id]; ok {
+		delete(b.subscribers, id)
+		return true
+	}
+	return false
+}
+
+// Dropped reports how many events were dropped for the given subscriber
+// because its channel buffer was full.
+func (b *EventBus) Dropped(id string) uint64 {
+	b.RLock()
+	sub, ok := b.subscribers[id]
+	b.RUnlock()
+
+	if !ok {
+		return 0
+	}
+	return atomic.LoadUint64(&sub.dropped)
+}
+
+// Publish copies e to every subscriber whose query matches it. A
+// subscriber whose buffer is full has the event dropped rather than
+// blocking the publisher.
+func (b *EventBus) Publish(e Event) {
+	b.RLock()
+	defer b.RUnlock()
+
+	for _, sub := range b.subscribers {
+		if !sub.query.matches(e) {
+			continue
+		}
+
+		select {
+		case sub.out <- e:
+		default:
+			atomic.AddUint64(&sub.dropped, 1)
+		}
+	}
+}
+
+// defaultEventBus is the bus every decoded subscribeMessage is published
+// to, giving callers a local pub/sub layer that mirrors the remote one.
+var defaultEventBus = NewEventBus()
+
+// SharedEventBus returns the EventBus that the subscribe response path
+// publishes every decoded message to.
+func SharedEventBus() *EventBus {
+	return defaultEventBus
+}
+
+// --- query parsing -----------------------------------------------------
+//
+// Queries support equality/comparison over Event fields and boolean
+// AND/OR between clauses, e.g.:
+//
+//	channel='ch1' AND flags>0
+//	channel='ch1' OR channel='ch2'
+
+type eventOp int
+
+const (
+	opEq eventOp = iota
+	opNeq
+	opLt
+	opLte
+	opGt
+	opGte
+)
+
+type eventClause struct {
+	field string
+	op    eventOp
+	value string
+}
+
+func (c eventClause) matches(e Event) bool {
+	raw, ok := e.field(c.field)
+	if !ok {
+		return false
+	}
+
+	switch v := raw.(type) {
+	case string:
+		return c.matchString(v)
+	case int:
+		return c.matchNumber(float64(v))
+	case uint64:
+		return c.matchNumber(float64(v))
+	default:
+		return false
+	}
+}
+
+func (c eventClause) matchString(v string) bool {
+	switch c.op {
+	case opEq:
+		return v == c.value
+	case opNeq:
+		return v != c.value
+	default:
+		// comparison operators only apply to numeric fields
+		return false
+	}
+}
+
+func (c eventClause) matchNumber(v float64) bool {
+	n, err := strconv.ParseFloat(c.value, 64)
+	if err != nil {
+		return false
+	}
+
+	switch c.op {
+	case opEq:
+		return v == n
+	case opNeq:
+		return v != n
+	case opLt:
+		return v < n
+	case opLte:
+		return v <= n
+	case opGt:
+		return v > n
+	case opGte:
+		return v >= n
+	}
+	return false
+}
+
+type boolOp int
+
+const (
+	boolAnd boolOp = iota
+	boolOr
+)
+
+// eventQuery is a flat list of clauses joined left-to-right by AND/OR,
+// which is all the backlog asks for: no operator precedence, no
+// parentheses.
+type eventQuery struct {
+	clauses []eventClause
+	joins   []boolOp // len(joins) == len(clauses)-1
+}
+
+func (q *eventQuery) matches(e Event) bool {
+	if len(q.clauses) == 0 {
+		return true
+	}
+
+	result := q.clauses[0].matches(e)
+	for i, join := range q.joins {
+		next := q.clauses[i+1].matches(e)
+		if join == boolAnd {
+			result = result && next
+		} else {
+			result = result || next
+		}
+	}
+	return result
+}
+
+func parseEventQuery(query string) (*eventQuery, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return &eventQuery{}, nil
+	}
+
+	parts := splitBoolean(query)
+	q := &eventQuery{}
+
+	for i, part := range parts {
+		if i%2 == 1 {
+			switch strings.ToUpper(strings.TrimSpace(part)) {
+			case "AND":
+				q.joins = append(q.joins, boolAnd)
+			case "OR":
+				q.joins = append(q.joins, boolOr)
+			default:
+				return nil, fmt.Errorf("messaging: invalid boolean operator %q", part)
+			}
+			continue
+		}
+
+		clause, err := parseClause(part)
+		if err != nil {
+			return nil, err
+		}
+		q.clauses = append(q.clauses, clause)
+	}
+
+	return q, nil
+}
+
+// splitBoolean splits "a AND b OR c" into ["a ", "AND", " b ", "OR", " c"],
+// case-insensitively (keywords come back upper-cased, clauses keep their
+// surrounding whitespace), without touching AND/OR that appear inside
+// quoted string literals.
+func splitBoolean(query string) []string {
+	var parts []string
+	var current strings.Builder
+	inQuote := false
+
+	runes := []rune(query)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if r == '\'' {
+			inQuote = !inQuote
+			current.WriteRune(r)
+			continue
+		}
+
+		if !inQuote {
+			if word, ok := matchKeyword(runes, i, "AND"); ok {
+				parts = append(parts, current.String(), "AND")
+				current.Reset()
+				i += len(word) - 1
+				continue
+			}
+			if word, ok := matchKeyword(runes, i, "OR"); ok {
+				parts = append(parts, current.String(), "OR")
+				current.Reset()
+				i += len(word) - 1
+				continue
+			}
+		}
+
+		current.WriteRune(r)
+	}
+	parts = append(parts, current.String())
+
+	return parts
+}
+
+// matchKeyword reports whether keyword occurs at runes[i], bounded by
+// whitespace (or string edges) so it isn't matched inside identifiers
+// like "brand".
+func matchKeyword(runes []rune, i int, keyword string) (string, bool) {
+	end := i + len(keyword)
+	if end > len(runes) {
+		return "", false
+	}
+	if !strings.EqualFold(string(runes[i:end]), keyword) {
+		return "", false
+	}
+	if i > 0 && !isBoundary(runes[i-1]) {
+		return "", false
+	}
+	if end < len(runes) && !isBoundary(runes[end]) {
+		return "", false
+	}
+	return string(runes[i:end]), true
+}
+
+func isBoundary(r rune) bool {
+	return r == ' ' || r == '\t'
+}
+
+var clauseOps = []struct {
+	token string
+	op    eventOp
+}{
+	{"!=", opNeq},
+	{"<=", opLte},
+	{">=", opGte},
+	{"=", opEq},
+	{"<", opLt},
+	{">", opGt},
+}
+
+func parseClause(s string) (eventClause, error) {
+	s = strings.TrimSpace(s)
+
+	for _, candidate := range clauseOps {
+		idx := strings.Index(s, candidate.token)
+		if idx < 0 {
+			continue
+		}
+
+		field := strings.TrimSpace(s[:idx])
+		value := strings.TrimSpace(s[idx+len(candidate.token):])
+		value = strings.Trim(value, "'\"")
+
+		if field == "" {
+			return eventClause{}, fmt.Errorf("messaging: invalid query clause %q", s)
+		}
+
+		return eventClause{field: field, op: candidate.op, value: value}, nil
+	}
+
+	return eventClause{}, fmt.Errorf("messaging: invalid query clause %q", s)
+}
@@ -0,0 +1,123 @@
+package messaging
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitBoolean(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"channel='ch1'", []string{"channel='ch1'"}},
+		{"channel='ch1' AND flags>0", []string{"channel='ch1' ", "AND", " flags>0"}},
+		{"channel='ch1' or channel='ch2'", []string{"channel='ch1' ", "OR", " channel='ch2'"}},
+		{"channel='brand' AND flags>0", []string{"channel='brand' ", "AND", " flags>0"}},
+		{"channel='has AND inside' AND flags>0", []string{"channel='has AND inside' ", "AND", " flags>0"}},
+	}
+
+	for _, tt := range tests {
+		got := splitBoolean(tt.query)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitBoolean(%q) = %#v, want %#v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestParseClause(t *testing.T) {
+	tests := []struct {
+		clause string
+		want   eventClause
+	}{
+		{"channel='ch1'", eventClause{field: "channel", op: opEq, value: "ch1"}},
+		{"channel!='ch1'", eventClause{field: "channel", op: opNeq, value: "ch1"}},
+		{"flags>=0", eventClause{field: "flags", op: opGte, value: "0"}},
+		{"flags<=0", eventClause{field: "flags", op: opLte, value: "0"}},
+		{"flags>0", eventClause{field: "flags", op: opGt, value: "0"}},
+		{"flags<0", eventClause{field: "flags", op: opLt, value: "0"}},
+	}
+
+	for _, tt := range tests {
+		got, err := parseClause(tt.clause)
+		if err != nil {
+			t.Fatalf("parseClause(%q) returned error: %v", tt.clause, err)
+		}
+		if got != tt.want {
+			t.Errorf("parseClause(%q) = %#v, want %#v", tt.clause, got, tt.want)
+		}
+	}
+
+	if _, err := parseClause("='ch1'"); err == nil {
+		t.Error("parseClause with empty field name should error")
+	}
+
+	if _, err := parseClause("no operator here"); err == nil {
+		t.Error("parseClause with no operator should error")
+	}
+}
+
+func TestParseEventQueryPrecedence(t *testing.T) {
+	// The grammar has no operator precedence: clauses are folded strictly
+	// left to right, so "a OR b AND c" evaluates as (a OR b) AND c, not
+	// a OR (b AND c).
+	q, err := parseEventQuery("channel='ch1' OR channel='ch2' AND flags>0")
+	if err != nil {
+		t.Fatalf("parseEventQuery returned error: %v", err)
+	}
+
+	// ch1 matches the first clause but flags>0 (the AND'd clause) is
+	// false, so under left-to-right folding the whole query is false.
+	if q.matches(Event{Channel: "ch1", Flags: 0}) {
+		t.Error("expected (ch1 OR ch2) AND flags>0 to be false when flags==0, left-to-right fold")
+	}
+
+	if !q.matches(Event{Channel: "ch1", Flags: 1}) {
+		t.Error("expected ch1 with flags>0 to match")
+	}
+}
+
+func TestParseEventQueryInvalidClause(t *testing.T) {
+	if _, err := parseEventQuery("no operator here AND flags>0"); err == nil {
+		t.Error("expected error for a clause with no recognized operator")
+	}
+}
+
+func TestParseEventQueryEmpty(t *testing.T) {
+	q, err := parseEventQuery("   ")
+	if err != nil {
+		t.Fatalf("parseEventQuery returned error: %v", err)
+	}
+	if !q.matches(Event{Channel: "anything"}) {
+		t.Error("empty query should match every event")
+	}
+}
+
+func TestEventBusSubscribeNegativeCapacity(t *testing.T) {
+	b := NewEventBus()
+
+	if _, _, err := b.Subscribe("channel='x'", -1); err == nil {
+		t.Error("expected error for negative capacity, got nil")
+	}
+}
+
+func TestEventBusPublishMatchesQuery(t *testing.T) {
+	b := NewEventBus()
+
+	_, out, err := b.Subscribe("channel='ch1'", 1)
+	if err != nil {
+		t.Fatalf("Subscribe returned error: %v", err)
+	}
+
+	b.Publish(Event{Channel: "ch2"})
+	b.Publish(Event{Channel: "ch1"})
+
+	select {
+	case e := <-out:
+		if e.Channel != "ch1" {
+			t.Errorf("got event for channel %q, want ch1", e.Channel)
+		}
+	default:
+		t.Fatal("expected a matching event to be delivered")
+	}
+}
@@ -0,0 +1,237 @@
+package messaging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+)
+
+// LogLevel orders the severities a MessageLogger can be asked to emit.
+type LogLevel int
+
+const (
+	LogLevelDebug LogLevel = iota
+	LogLevelInfo
+	LogLevelWarn
+	LogLevelError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogLevelDebug:
+		return "DEBUG"
+	case LogLevelInfo:
+		return "INFO"
+	case LogLevelWarn:
+		return "WARN"
+	case LogLevelError:
+		return "ERROR"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// MessageLogger is the logging seam the subscribe response path and
+// subscriptionEntity write through, replacing the old mix of
+// infoLogger.Printf calls and writeMessageLog. Swapping in a structured
+// sink (piping JSON into ELK/Loki, say) no longer requires regex-scraping
+// human-readable log lines.
+type MessageLogger interface {
+	LogMessage(msg *subscribeMessage)
+	LogSubscriptionEvent(name, action string, fields map[string]interface{})
+	Log(level LogLevel, format string, args ...interface{})
+	SetLevel(level LogLevel)
+}
+
+// jsonMessageLogger is the default MessageLogger: one JSON object per
+// event, newline-delimited.
+type jsonMessageLogger struct {
+	mu    sync.Mutex
+	out   io.Writer
+	level LogLevel
+}
+
+// NewJSONMessageLogger creates a MessageLogger that writes one JSON
+// object per event to out.
+func NewJSONMessageLogger(out io.Writer) MessageLogger {
+	return &jsonMessageLogger{out: out, level: LogLevelInfo}
+}
+
+func (l *jsonMessageLogger) SetLevel(level LogLevel) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.level = level
+}
+
+func (l *jsonMessageLogger) enabled(level LogLevel) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return level >= l.level
+}
+
+func (l *jsonMessageLogger) write(fields map[string]interface{}) {
+	line, err := json.Marshal(fields)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.out.Write(line)
+}
+
+func (l *jsonMessageLogger) LogMessage(msg *subscribeMessage) {
+	if !l.enabled(LogLevelInfo) {
+		return
+	}
+
+	l.write(map[string]interface{}{
+		"level":              LogLevelInfo.String(),
+		"channel":            msg.Channel,
+		"flags":              msg.Flags,
+		"issuing_client_id":  msg.IssuingClientId,
+		"originating_tt":     msg.OriginatingTimetoken.Timetoken,
+		"publish_tt":         msg.PublishTimetokenMetadata.Timetoken,
+		"payload":            msg.Payload,
+		"sequence":           msg.SequenceNumber,
+		"shard":              msg.Shard,
+		"subscription_match": msg.SubscriptionMatch,
+		"user_metadata":      msg.UserMetadata,
+	})
+}
+
+func (l *jsonMessageLogger) LogSubscriptionEvent(name, action string, fields map[string]interface{}) {
+	if !l.enabled(LogLevelDebug) {
+		return
+	}
+
+	out := map[string]interface{}{
+		"level":  LogLevelDebug.String(),
+		"name":   name,
+		"action": action,
+	}
+	for k, v := range fields {
+		out[k] = v
+	}
+	l.write(out)
+}
+
+func (l *jsonMessageLogger) Log(level LogLevel, format string, args ...interface{}) {
+	if !l.enabled(level) {
+		return
+	}
+
+	l.write(map[string]interface{}{
+		"level":   level.String(),
+		"message": fmt.Sprintf(format, args...),
+	})
+}
+
+// logAdapter wraps an existing *log.Logger so deployments that already
+// configured one keep working unchanged behind the new MessageLogger
+// seam.
+type logAdapter struct {
+	mu     sync.Mutex
+	logger *log.Logger
+	level  LogLevel
+}
+
+// NewLogAdapter adapts logger to MessageLogger, preserving the
+// human-readable "LEVEL: ..." line format existing deployments already
+// parse. The adapter starts at LogLevelInfo, so the "INFO: ITEMS: ..."
+// lines from LogSubscriptionEvent are written only after
+// SetLevel(LogLevelDebug).
+func NewLogAdapter(logger *log.Logger) MessageLogger {
+	return &logAdapter{logger: logger, level: LogLevelInfo}
+}
+
+func (a *logAdapter) SetLevel(level LogLevel) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.level = level
+}
+
+func (a *logAdapter) enabled(level LogLevel) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return level >= a.level
+}
+
+func (a *logAdapter) LogMessage(msg *subscribeMessage) {
+	if !a.enabled(LogLevelInfo) {
+		return
+	}
+
+	a.logger.Printf("INFO: Channel, %s", msg.Channel)
+	a.logger.Printf("INFO: Flags, %d", msg.Flags)
+	a.logger.Printf("INFO: IssuingClientId, %s", msg.IssuingClientId)
+	a.logger.Printf("INFO: OriginatingTimetoken Region, %d", msg.OriginatingTimetoken.Region)
+	a.logger.Printf("INFO: OriginatingTimetoken Timetoken, %s", msg.OriginatingTimetoken.Timetoken)
+	a.logger.Printf("INFO: PublishTimetokenMetadata Region, %d", msg.PublishTimetokenMetadata.Region)
+	a.logger.Printf("INFO: PublishTimetokenMetadata Timetoken, %s", msg.PublishTimetokenMetadata.Timetoken)
+
+	if strPayload, ok := msg.Payload.(string); ok {
+		a.logger.Printf("INFO: Payload, %s", strPayload)
+	} else {
+		a.logger.Printf("INFO: Payload, not converted to string %s", msg.Payload)
+	}
+
+	a.logger.Printf("INFO: SequenceNumber, %d", msg.SequenceNumber)
+	a.logger.Printf("INFO: Shard, %s", msg.Shard)
+	a.logger.Printf("INFO: SubscribeKey, %s", msg.SubscribeKey)
+	a.logger.Printf("INFO: SubscriptionMatch, %s", msg.SubscriptionMatch)
+
+	if strUserMetadata, ok := msg.UserMetadata.(string); ok {
+		a.logger.Printf("INFO: UserMetadata, %s", strUserMetadata)
+	} else {
+		a.logger.Printf("INFO: UserMetadata, not converted to string")
+	}
+}
+
+func (a *logAdapter) LogSubscriptionEvent(name, action string, fields map[string]interface{}) {
+	if !a.enabled(LogLevelDebug) {
+		return
+	}
+
+	a.logger.Printf("INFO: ITEMS: %s '%s' %v", action, name, fields)
+}
+
+func (a *logAdapter) Log(level LogLevel, format string, args ...interface{}) {
+	if !a.enabled(level) {
+		return
+	}
+
+	a.logger.Printf("%s: %s", level.String(), fmt.Sprintf(format, args...))
+}
+
+// defaultMessageLogger is what the subscribe response path and
+// subscriptionEntity log through until the package-level
+// SetMessageLogger installs something else.
+var (
+	defaultMessageLoggerMu sync.RWMutex
+	defaultMessageLogger   MessageLogger = NewJSONMessageLogger(os.Stdout)
+)
+
+// SetMessageLogger installs l as the MessageLogger used package-wide.
+func SetMessageLogger(l MessageLogger) {
+	defaultMessageLoggerMu.Lock()
+	defer defaultMessageLoggerMu.Unlock()
+
+	defaultMessageLogger = l
+}
+
+func currentMessageLogger() MessageLogger {
+	defaultMessageLoggerMu.RLock()
+	defer defaultMessageLoggerMu.RUnlock()
+
+	return defaultMessageLogger
+}
@@ -0,0 +1,76 @@
+package messaging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestJSONMessageLoggerLevelFiltering(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewJSONMessageLogger(&buf)
+	l.SetLevel(LogLevelWarn)
+
+	l.Log(LogLevelInfo, "should be dropped")
+	if buf.Len() != 0 {
+		t.Fatalf("Log below the configured level wrote output: %q", buf.String())
+	}
+
+	l.Log(LogLevelError, "boom %d", 1)
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(bytes.TrimSpace(buf.Bytes()), &fields); err != nil {
+		t.Fatalf("output is not valid JSON: %v (%q)", err, buf.String())
+	}
+
+	if fields["level"] != "ERROR" {
+		t.Errorf("level = %v, want ERROR", fields["level"])
+	}
+	if fields["message"] != "boom 1" {
+		t.Errorf("message = %v, want %q", fields["message"], "boom 1")
+	}
+}
+
+func TestJSONMessageLoggerLogSubscriptionEvent(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewJSONMessageLogger(&buf)
+	l.SetLevel(LogLevelDebug)
+
+	l.LogSubscriptionEvent("ch1", "add", map[string]interface{}{"id": "abc"})
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(bytes.TrimSpace(buf.Bytes()), &fields); err != nil {
+		t.Fatalf("output is not valid JSON: %v (%q)", err, buf.String())
+	}
+
+	if fields["name"] != "ch1" || fields["action"] != "add" || fields["id"] != "abc" {
+		t.Errorf("unexpected fields: %v", fields)
+	}
+}
+
+func TestLogAdapterHumanReadableFormat(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogAdapter(log.New(&buf, "", 0))
+
+	logger.Log(LogLevelInfo, "hello %s", "world")
+
+	if !strings.Contains(buf.String(), "INFO: hello world") {
+		t.Errorf("output = %q, want it to contain %q", buf.String(), "INFO: hello world")
+	}
+}
+
+func TestSetMessageLoggerSwapsTheDefault(t *testing.T) {
+	original := currentMessageLogger()
+	defer SetMessageLogger(original)
+
+	var buf bytes.Buffer
+	SetMessageLogger(NewJSONMessageLogger(&buf))
+
+	currentMessageLogger().Log(LogLevelInfo, "via the installed logger")
+
+	if buf.Len() == 0 {
+		t.Error("currentMessageLogger() did not route through the logger installed by SetMessageLogger")
+	}
+}
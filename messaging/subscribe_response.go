@@ -1,6 +1,7 @@
 package messaging
 
 import (
+	"encoding/json"
 	"strings"
 )
 
@@ -33,10 +34,10 @@ type subscribeMessage struct {
 
 func (env *subscribeEnvelope) getChannelsAndGroups(pub *Pubnub) (channels, channelGroups []string) {
 	if env.Messages != nil {
-		count := 0
 		for _, msg := range env.Messages {
-			count++
-			msg.writeMessageLog(count, pub)
+			msg.writeMessageLog(pub)
+			defaultEventBus.Publish(eventFromMessage(&msg))
+			msg.deliver()
 			channels = append(channels, msg.Channel)
 			if (msg.Channel != msg.SubscriptionMatch) &&
 				(!strings.Contains(msg.SubscriptionMatch, ".*")) &&
@@ -48,32 +49,30 @@ func (env *subscribeEnvelope) getChannelsAndGroups(pub *Pubnub) (channels, chann
 	return channels, channelGroups
 }
 
-func (msg *subscribeMessage) writeMessageLog(count int, pub *Pubnub) {
-	// start logging
-	infoLogger.Printf("INFO: -----Message %d-----", count)
-	infoLogger.Printf("INFO: Channel, %s", msg.Channel)
-	infoLogger.Printf("INFO: Flags, %d", msg.Flags)
-	infoLogger.Printf("INFO: IssuingClientId, %s", msg.IssuingClientId)
-	infoLogger.Printf("INFO: OriginatingTimetoken Region, %d", msg.OriginatingTimetoken.Region)
-	infoLogger.Printf("INFO: OriginatingTimetoken Timetoken, %s", msg.OriginatingTimetoken.Timetoken)
-	infoLogger.Printf("INFO: PublishTimetokenMetadata Region, %d", msg.PublishTimetokenMetadata.Region)
-	infoLogger.Printf("INFO: PublishTimetokenMetadata Timetoken, %s", msg.PublishTimetokenMetadata.Timetoken)
+func (msg *subscribeMessage) writeMessageLog(pub *Pubnub) {
+	currentMessageLogger().LogMessage(msg)
+}
 
-	strPayload, ok := msg.Payload.(string)
-	if ok {
-		infoLogger.Printf("INFO: Payload, %s", strPayload)
-	} else {
-		infoLogger.Printf("INFO: Payload, not converted to string %s", msg.Payload)
+// deliver fans msg's payload out to every SuccessChannel subscribed to its
+// channel, and to its channel group's subscribers if it matched one, via
+// defaultSubscriptions so each independent subscriber gets exactly one
+// copy instead of one clobbering another under the same name. It also
+// appends the message to the shared replay buffer for the same names, so
+// a TopicPartitionSubscription created via SubscribeWithReplay actually
+// sees live messages instead of only ones published directly in tests.
+func (msg *subscribeMessage) deliver() {
+	data, err := json.Marshal(msg.Payload)
+	if err != nil {
+		return
 	}
-	infoLogger.Printf("INFO: SequenceNumber, %d", msg.SequenceNumber)
-	infoLogger.Printf("INFO: Shard, %s", msg.Shard)
-	infoLogger.Printf("INFO: SubscribeKey, %s", msg.SubscribeKey)
-	infoLogger.Printf("INFO: SubscriptionMatch, %s", msg.SubscriptionMatch)
-	strUserMetadata, ok := msg.UserMetadata.(string)
-	if ok {
-		infoLogger.Printf("INFO: UserMetadata, %s", strUserMetadata)
-	} else {
-		infoLogger.Printf("INFO: UserMetadata, not converted to string")
+
+	timetoken := msg.PublishTimetokenMetadata.Timetoken
+
+	defaultSubscriptions.Deliver(msg.Channel, data)
+	defaultSubscriptions.Publish(msg.Channel, timetoken, data)
+
+	if msg.SubscriptionMatch != "" && msg.SubscriptionMatch != msg.Channel {
+		defaultSubscriptions.Deliver(msg.SubscriptionMatch, data)
+		defaultSubscriptions.Publish(msg.SubscriptionMatch, timetoken, data)
 	}
-	// end logging
 }
@@ -1,8 +1,9 @@
 package messaging
 
 import (
+	"context"
+	"crypto/rand"
 	"fmt"
-	"log"
 	"strings"
 	"sync"
 )
@@ -16,58 +17,83 @@ const (
 type connectionAction int
 
 type connectionEvent struct {
+	ID      string
 	Channel string
 	Source  string
 	Action  connectionAction
 	Type    responseType
 }
 
-func newConnectionEventForChannel(channel string,
+func newConnectionEventForChannel(id string, channel string,
 	action connectionAction) *connectionEvent {
 	return &connectionEvent{
+		ID:      id,
 		Channel: channel,
 		Action:  action,
 		Type:    channelResponse,
 	}
 }
 
-func newConnectionEventForChannelGroup(group string,
+func newConnectionEventForChannelGroup(id string, group string,
 	action connectionAction) *connectionEvent {
 	return &connectionEvent{
+		ID:     id,
 		Source: group,
 		Action: action,
 		Type:   channelGroupResponse,
 	}
 }
 
+// Bytes renders the connection ack/error payload sent on the subscription's
+// SuccessChannel/ErrorChannel. The subscription ID is appended as a fourth
+// array element so a consumer juggling several Add/AddConnected calls can
+// correlate this event back to the specific subscription that produced it.
 func (e connectionEvent) Bytes() []byte {
 	switch e.Type {
 	case channelResponse:
 		fallthrough
 	case wildcardResponse:
 		return []byte(fmt.Sprintf(
-			"[1, \"%s channel '%s' %sed\", \"%s\"]",
+			"[1, \"%s channel '%s' %sed\", \"%s\", \"%s\"]",
 			stringPresenceOrSubscribe(e.Channel),
 			removePnpres(e.Channel), e.Action,
-			removePnpres(e.Channel)))
+			removePnpres(e.Channel), e.ID))
 
 	case channelGroupResponse:
 		return []byte(fmt.Sprintf(
-			"[1, \"%s channel group '%s' %sed\", \"%s\"]",
+			"[1, \"%s channel group '%s' %sed\", \"%s\", \"%s\"]",
 			stringPresenceOrSubscribe(e.Source),
 			removePnpres(e.Source), e.Action,
-			strings.Replace(e.Source, presenceSuffix, "", -1)))
+			strings.Replace(e.Source, presenceSuffix, "", -1), e.ID))
 
 	default:
 		panic(fmt.Sprintf("Undefined response type: %d", e.Type))
 	}
 }
 
+// newSubscriptionID generates a random RFC 4122 version 4 UUID to
+// identify a single Add/AddConnected call for the lifetime of the
+// subscription.
+func newSubscriptionID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
 type subscriptionItem struct {
+	ID             string
 	Name           string
 	SuccessChannel chan<- []byte
 	ErrorChannel   chan<- []byte
 	Connected      bool
+	ctx            context.Context
+	cancel         context.CancelFunc
 }
 
 func (e *subscriptionItem) SetConnected() (changed bool) {
@@ -78,9 +104,25 @@ func (e *subscriptionItem) SetConnected() (changed bool) {
 	return false
 }
 
+// Done returns a channel that closes once the subscription's context is
+// canceled, so a goroutine reading SuccessChannel/ErrorChannel can select
+// on it for prompt shutdown instead of polling an abort marker.
+func (e *subscriptionItem) Done() <-chan struct{} {
+	return e.ctx.Done()
+}
+
+// Cancel cancels the subscription's context.
+func (e *subscriptionItem) Cancel() {
+	e.cancel()
+}
+
 type subscriptionEntity struct {
 	sync.RWMutex
-	items         map[string]*subscriptionItem
+	items         map[string]*subscriptionItem // keyed by subscription ID
+	byName        map[string][]string          // channel/group name -> subscription IDs
+	buffers       map[string]*topicPartitionBuffer
+	bufferRefs    map[string]int // channel/group name -> live TopicPartitionSubscription count
+	topicSubs     map[string]*TopicPartitionSubscription
 	abortedMarker bool
 }
 
@@ -88,55 +130,190 @@ func newSubscriptionEntity() *subscriptionEntity {
 	e := new(subscriptionEntity)
 
 	e.items = make(map[string]*subscriptionItem)
+	e.byName = make(map[string][]string)
+	e.buffers = make(map[string]*topicPartitionBuffer)
+	e.bufferRefs = make(map[string]int)
+	e.topicSubs = make(map[string]*TopicPartitionSubscription)
 
 	return e
 }
 
-func (e *subscriptionEntity) Add(name string,
-	successChannel chan<- []byte, errorChannel chan<- []byte, logger *log.Logger) {
-	e.add(name, false, successChannel, errorChannel, logger)
+// bufferFor returns the shared topicPartitionBuffer for name, creating it
+// on first use.
+func (e *subscriptionEntity) bufferFor(name string) *topicPartitionBuffer {
+	e.Lock()
+	defer e.Unlock()
+
+	buf, ok := e.buffers[name]
+	if !ok {
+		buf = newTopicPartitionBuffer(defaultTopicPartitionCapacity)
+		e.buffers[name] = buf
+	}
+	return buf
 }
 
-func (e *subscriptionEntity) AddConnected(name string,
-	successChannel chan<- []byte, errorChannel chan<- []byte, logger *log.Logger) {
-	e.add(name, true, successChannel, errorChannel, logger)
+// Publish appends a decoded message to the shared buffer for name so
+// every TopicPartitionSubscription on it can pick it up via its own
+// cursor. The subscribe loop calls this once per decoded message; each
+// TopicPartitionSubscription then reads it from the shared buffer rather
+// than receiving its own copy.
+func (e *subscriptionEntity) Publish(name, timetoken string, data []byte) {
+	e.bufferFor(name).append(bufferedMessage{Timetoken: timetoken, Data: data})
 }
 
-func (e *subscriptionEntity) add(name string, connected bool,
-	successChannel chan<- []byte, errorChannel chan<- []byte, logger *log.Logger) {
+// SubscribeWithReplay registers a cursor-based subscription on name,
+// backed by the shared ring buffer of recent messages. If replay > 0 the
+// cursor starts that many messages behind the current head (clamped to
+// what the buffer still retains) so the caller sees recent history
+// before live messages.
+func (e *subscriptionEntity) SubscribeWithReplay(name string, replay int) (id string, sub *TopicPartitionSubscription) {
+	buf := e.bufferFor(name)
+	cursor := buf.cursorFor(replay)
 
-	logger.Printf("INFO: ITEMS: Adding item '%s', connected: %t", name, connected)
+	id = newSubscriptionID()
+	sub = newTopicPartitionSubscription(context.Background(), id, name, buf, cursor)
 
 	e.Lock()
-	defer e.Unlock()
+	e.topicSubs[id] = sub
+	e.bufferRefs[name]++
+	e.Unlock()
 
-	if _, ok := e.items[name]; ok {
-		logger.Printf("INFO: ITEMS: Item '%s' is not added since it's already exists", name)
-		return
+	return id, sub
+}
+
+// UnsubscribeTopicPartition cancels and removes a subscription created by
+// SubscribeWithReplay. Once name's last TopicPartitionSubscription is
+// gone, its shared buffer is dropped too instead of retaining history
+// nobody can read anymore.
+func (e *subscriptionEntity) UnsubscribeTopicPartition(id string) bool {
+	e.Lock()
+	sub, ok := e.topicSubs[id]
+	if ok {
+		delete(e.topicSubs, id)
+		e.bufferRefs[sub.Name]--
+		if e.bufferRefs[sub.Name] <= 0 {
+			delete(e.bufferRefs, sub.Name)
+			delete(e.buffers, sub.Name)
+		}
+	}
+	e.Unlock()
+
+	if !ok {
+		return false
 	}
 
+	sub.Cancel()
+	return true
+}
+
+// Add registers a new subscriber under name, deriving its lifetime from
+// parent so callers can bound it with context.WithTimeout/WithCancel. A
+// nil parent defaults to context.Background().
+func (e *subscriptionEntity) Add(parent context.Context, name string,
+	successChannel chan<- []byte, errorChannel chan<- []byte, logger MessageLogger) string {
+	return e.add(parent, name, false, successChannel, errorChannel, logger)
+}
+
+func (e *subscriptionEntity) AddConnected(parent context.Context, name string,
+	successChannel chan<- []byte, errorChannel chan<- []byte, logger MessageLogger) string {
+	return e.add(parent, name, true, successChannel, errorChannel, logger)
+}
+
+func (e *subscriptionEntity) add(parent context.Context, name string, connected bool,
+	successChannel chan<- []byte, errorChannel chan<- []byte, logger MessageLogger) string {
+
+	if parent == nil {
+		parent = context.Background()
+	}
+
+	id := newSubscriptionID()
+	ctx, cancel := context.WithCancel(parent)
+
+	logger.LogSubscriptionEvent(name, "add", map[string]interface{}{
+		"id": id, "connected": connected,
+	})
+
+	e.Lock()
+	defer e.Unlock()
+
 	item := &subscriptionItem{
+		ID:             id,
 		Name:           name,
 		SuccessChannel: successChannel,
 		ErrorChannel:   errorChannel,
 		Connected:      connected,
+		ctx:            ctx,
+		cancel:         cancel,
 	}
 
-	e.items[name] = item
+	e.items[id] = item
+	e.byName[name] = append(e.byName[name], id)
+
+	return id
 }
 
-func (e *subscriptionEntity) Remove(name string, logger *log.Logger) bool {
-	logger.Printf("INFO: ITEMS: Removing item '%s'", name)
+// Unsubscribe removes a single subscription identified by the ID returned
+// from Add/AddConnected, leaving any other subscriber on the same name
+// untouched.
+func (e *subscriptionEntity) Unsubscribe(id string, logger MessageLogger) bool {
+	e.Lock()
+	defer e.Unlock()
+
+	item, ok := e.items[id]
+	if !ok {
+		return false
+	}
+
+	logger.LogSubscriptionEvent(item.Name, "unsubscribe", map[string]interface{}{"id": id})
+
+	item.Cancel()
+	delete(e.items, id)
+	e.removeIDFromName(item.Name, id)
+
+	return true
+}
+
+// removeIDFromName drops id from the name->IDs index, pruning the name
+// entry entirely once it has no more subscribers. Callers must hold the
+// write lock.
+func (e *subscriptionEntity) removeIDFromName(name string, id string) {
+	ids := e.byName[name]
+	for i, existingID := range ids {
+		if existingID == id {
+			ids = append(ids[:i], ids[i+1:]...)
+			break
+		}
+	}
+
+	if len(ids) == 0 {
+		delete(e.byName, name)
+	} else {
+		e.byName[name] = ids
+	}
+}
+
+// Remove removes every subscription registered under name, e.g. when a
+// channel/group is unsubscribed entirely.
+func (e *subscriptionEntity) Remove(name string, logger MessageLogger) bool {
+	logger.LogSubscriptionEvent(name, "remove", nil)
 
 	e.Lock()
 	defer e.Unlock()
 
-	if _, ok := e.items[name]; ok {
-		delete(e.items, name)
+	ids, ok := e.byName[name]
+	if !ok {
+		return false
+	}
 
-		return true
+	for _, id := range ids {
+		if item, ok := e.items[id]; ok {
+			item.Cancel()
+		}
+		delete(e.items, id)
 	}
-	return false
+	delete(e.byName, name)
+
+	return true
 }
 
 func (e *subscriptionEntity) Length() int {
@@ -150,10 +327,7 @@ func (e *subscriptionEntity) Exist(name string) bool {
 	e.RLock()
 	defer e.RUnlock()
 
-	if _, ok := e.items[name]; ok {
-		return true
-	}
-	return false
+	return len(e.byName[name]) > 0
 }
 
 func (e *subscriptionEntity) Empty() bool {
@@ -163,23 +337,67 @@ func (e *subscriptionEntity) Empty() bool {
 	return len(e.items) == 0
 }
 
-func (e *subscriptionEntity) Get(name string) (*subscriptionItem, bool) {
+// Get looks up a single subscription by the ID returned from
+// Add/AddConnected.
+func (e *subscriptionEntity) Get(id string) (*subscriptionItem, bool) {
 	e.RLock()
 	defer e.RUnlock()
 
-	if _, ok := e.items[name]; ok {
-		return e.items[name], true
+	if item, ok := e.items[id]; ok {
+		return item, true
 	}
 	return nil, false
 }
 
+// GetByName returns every subscription currently registered for name, so
+// fan-out can deliver one copy of a message to each independent
+// subscriber instead of keeping only the first one keyed by name alone.
+func (e *subscriptionEntity) GetByName(name string) ([]*subscriptionItem, bool) {
+	e.RLock()
+	defer e.RUnlock()
+
+	ids, ok := e.byName[name]
+	if !ok || len(ids) == 0 {
+		return nil, false
+	}
+
+	items := make([]*subscriptionItem, 0, len(ids))
+	for _, id := range ids {
+		if item, ok := e.items[id]; ok {
+			items = append(items, item)
+		}
+	}
+
+	return items, len(items) > 0
+}
+
+// Deliver sends data to the SuccessChannel of every subscription
+// registered under name, using GetByName's ID-keyed lookup so a
+// channel/group with several independent subscribers each get their own
+// copy instead of the old name-keyed map ignoring every Add call after
+// the first. Delivery to a single subscriber never blocks past that
+// subscriber's own cancellation.
+func (e *subscriptionEntity) Deliver(name string, data []byte) {
+	items, ok := e.GetByName(name)
+	if !ok {
+		return
+	}
+
+	for _, item := range items {
+		select {
+		case item.SuccessChannel <- data:
+		case <-item.Done():
+		}
+	}
+}
+
 func (e *subscriptionEntity) Names() []string {
 	e.RLock()
 	defer e.RUnlock()
 
 	var names = []string{}
 
-	for k, _ := range e.items {
+	for k := range e.byName {
 		names = append(names, k)
 	}
 
@@ -209,11 +427,16 @@ func (e *subscriptionEntity) ConnectedNames() []string {
 	e.RLock()
 	defer e.RUnlock()
 
+	seen := make(map[string]bool, len(e.byName))
 	var names = []string{}
 
-	for k, item := range e.items {
-		if item.Connected {
-			names = append(names, k)
+	for k, ids := range e.byName {
+		for _, id := range ids {
+			if item, ok := e.items[id]; ok && item.Connected && !seen[k] {
+				names = append(names, k)
+				seen[k] = true
+				break
+			}
 		}
 	}
 
@@ -226,36 +449,66 @@ func (e *subscriptionEntity) ConnectedNamesString() string {
 	return strings.Join(names, ",")
 }
 
+// Clear cancels every subscription, including TopicPartitionSubscriptions
+// registered via SubscribeWithReplay, and resets the entity to empty.
 func (e *subscriptionEntity) Clear() {
 	e.Lock()
 	defer e.Unlock()
 
+	for _, item := range e.items {
+		item.Cancel()
+	}
+	for _, sub := range e.topicSubs {
+		sub.Cancel()
+	}
+
 	e.items = make(map[string]*subscriptionItem)
+	e.byName = make(map[string][]string)
+	e.buffers = make(map[string]*topicPartitionBuffer)
+	e.bufferRefs = make(map[string]int)
+	e.topicSubs = make(map[string]*TopicPartitionSubscription)
+	e.abortedMarker = false
 }
 
-func (e *subscriptionEntity) Abort(logger *log.Logger) {
-	logger.Printf("INFO: ITEMS: Aborting")
+// Abort cancels every subscription's context, including outstanding
+// TopicPartitionSubscriptions, so any goroutine selecting on a Done()
+// channel shuts down immediately instead of polling a marker, and flags
+// the entity as aborted. It keeps the old abort-then-apply staging:
+// items/byName/buffers aren't wiped until ApplyAbort runs, so a caller
+// that still inspects entity state between the two calls sees what it
+// used to.
+func (e *subscriptionEntity) Abort(logger MessageLogger) {
+	logger.Log(LogLevelInfo, "ITEMS: Aborting")
 
 	e.Lock()
 	defer e.Unlock()
 
+	for _, item := range e.items {
+		item.Cancel()
+	}
+	for _, sub := range e.topicSubs {
+		sub.Cancel()
+	}
+
 	e.abortedMarker = true
 }
 
-func (e *subscriptionEntity) ApplyAbort(logger *log.Logger) {
-	logger.Printf("INFO: ITEMS: Applying abort")
+// ApplyAbort clears the entity if Abort has marked it aborted, completing
+// the old two-phase abort-then-apply dance.
+func (e *subscriptionEntity) ApplyAbort(logger MessageLogger) {
+	logger.Log(LogLevelInfo, "ITEMS: Applying abort")
 
 	e.Lock()
 	abortedMarker := e.abortedMarker
 	e.Unlock()
 
-	if abortedMarker == true {
+	if abortedMarker {
 		e.Clear()
 	}
 }
 
-func (e *subscriptionEntity) ResetConnected(logger *log.Logger) {
-	logger.Printf("INFO: ITEMS: Resetting connected flag")
+func (e *subscriptionEntity) ResetConnected(logger MessageLogger) {
+	logger.Log(LogLevelInfo, "ITEMS: Resetting connected flag")
 
 	e.Lock()
 	defer e.Unlock()
@@ -265,27 +518,37 @@ func (e *subscriptionEntity) ResetConnected(logger *log.Logger) {
 	}
 }
 
-func (e *subscriptionEntity) SetConnected(logger *log.Logger) (changedItemNames []string) {
-	logger.Printf("INFO: ITEMS: Setting items '%s' as connected",
-		strings.Join(changedItemNames, ","))
-
+func (e *subscriptionEntity) SetConnected(logger MessageLogger) (changedItemNames []string) {
 	e.Lock()
 	defer e.Unlock()
 
-	for name, item := range e.items {
+	for _, item := range e.items {
 		if item.SetConnected() == true {
-			changedItemNames = append(changedItemNames, name)
+			changedItemNames = append(changedItemNames, item.Name)
 		}
 	}
 
+	logger.Log(LogLevelInfo, "ITEMS: Setting items '%s' as connected",
+		strings.Join(changedItemNames, ","))
+
 	return changedItemNames
 }
 
-// CreateSubscriptionChannels creates channels for subscription
-func CreateSubscriptionChannels() (chan []byte, chan []byte) {
+// CreateSubscriptionChannels creates channels for subscription, plus a
+// Background-derived context and its cancel func for callers that don't
+// already have a context to thread through Add/AddConnected.
+func CreateSubscriptionChannels() (chan []byte, chan []byte, context.Context, context.CancelFunc) {
 
 	successResponse := make(chan []byte)
 	errorResponse := make(chan []byte)
+	ctx, cancel := context.WithCancel(context.Background())
 
-	return successResponse, errorResponse
+	return successResponse, errorResponse, ctx, cancel
 }
+
+// defaultSubscriptions is the subscriptionEntity every decoded
+// subscribeMessage is fanned out through, mirroring defaultEventBus: the
+// subscribe response path doesn't hold a *Pubnub field to reach a
+// connection-scoped entity, so it delivers through this package-level one
+// instead.
+var defaultSubscriptions = newSubscriptionEntity()
@@ -0,0 +1,152 @@
+package messaging
+
+import (
+	"testing"
+	"time"
+)
+
+// noopLogger discards every call so tests don't need a real sink.
+type noopLogger struct{}
+
+func (noopLogger) LogMessage(msg *subscribeMessage)                                        {}
+func (noopLogger) LogSubscriptionEvent(name, action string, fields map[string]interface{}) {}
+func (noopLogger) Log(level LogLevel, format string, args ...interface{})                  {}
+func (noopLogger) SetLevel(level LogLevel)                                                 {}
+
+func TestSubscriptionEntityAddDistinctIDsAndDeliver(t *testing.T) {
+	e := newSubscriptionEntity()
+
+	success1 := make(chan []byte, 1)
+	error1 := make(chan []byte, 1)
+	success2 := make(chan []byte, 1)
+	error2 := make(chan []byte, 1)
+
+	id1 := e.Add(nil, "ch1", success1, error1, noopLogger{})
+	id2 := e.Add(nil, "ch1", success2, error2, noopLogger{})
+
+	if id1 == id2 {
+		t.Fatalf("two Add() calls on the same name returned the same ID %q", id1)
+	}
+
+	items, ok := e.GetByName("ch1")
+	if !ok || len(items) != 2 {
+		t.Fatalf("GetByName(ch1) = %v, %v, want 2 items", items, ok)
+	}
+
+	e.Deliver("ch1", []byte("payload"))
+
+	select {
+	case got := <-success1:
+		if string(got) != "payload" {
+			t.Errorf("subscriber 1 got %q, want payload", got)
+		}
+	default:
+		t.Error("subscriber 1 never received its copy")
+	}
+
+	select {
+	case got := <-success2:
+		if string(got) != "payload" {
+			t.Errorf("subscriber 2 got %q, want payload", got)
+		}
+	default:
+		t.Error("subscriber 2 never received its copy")
+	}
+
+	// Exactly one copy each: the buffered channel should now be empty.
+	select {
+	case extra := <-success1:
+		t.Errorf("subscriber 1 received a second copy: %q", extra)
+	default:
+	}
+}
+
+func TestSubscriptionEntityUnsubscribeLeavesOthers(t *testing.T) {
+	e := newSubscriptionEntity()
+
+	success1 := make(chan []byte, 1)
+	success2 := make(chan []byte, 1)
+
+	id1 := e.Add(nil, "ch1", success1, nil, noopLogger{})
+	e.Add(nil, "ch1", success2, nil, noopLogger{})
+
+	if !e.Unsubscribe(id1, noopLogger{}) {
+		t.Fatal("Unsubscribe(id1) returned false")
+	}
+
+	items, ok := e.GetByName("ch1")
+	if !ok || len(items) != 1 {
+		t.Fatalf("GetByName(ch1) after unsubscribing one = %v, %v, want 1 item left", items, ok)
+	}
+	if items[0].SuccessChannel != (chan<- []byte)(success2) {
+		t.Error("the remaining subscriber is not the one that stayed subscribed")
+	}
+}
+
+func TestSubscriptionItemDoneUnblocksOnCancel(t *testing.T) {
+	e := newSubscriptionEntity()
+	id := e.Add(nil, "ch1", make(chan []byte), make(chan []byte), noopLogger{})
+
+	item, ok := e.Get(id)
+	if !ok {
+		t.Fatal("Get(id) returned false right after Add")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		<-item.Done()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("Done() closed before the subscription was canceled")
+	default:
+	}
+
+	if !e.Unsubscribe(id, noopLogger{}) {
+		t.Fatal("Unsubscribe returned false")
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Done() did not unblock after Unsubscribe canceled the subscription")
+	}
+}
+
+func TestSubscriptionEntityAbortUnblocksDone(t *testing.T) {
+	e := newSubscriptionEntity()
+	id := e.Add(nil, "ch1", make(chan []byte), make(chan []byte), noopLogger{})
+
+	item, ok := e.Get(id)
+	if !ok {
+		t.Fatal("Get(id) returned false right after Add")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		<-item.Done()
+		close(done)
+	}()
+
+	e.Abort(noopLogger{})
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Done() did not unblock after Abort canceled the subscription")
+	}
+
+	// Abort only cancels and marks; the entity itself isn't cleared
+	// until ApplyAbort runs.
+	if e.Length() != 1 {
+		t.Errorf("Length() after Abort = %d, want 1 (staged until ApplyAbort)", e.Length())
+	}
+
+	e.ApplyAbort(noopLogger{})
+
+	if e.Length() != 0 {
+		t.Errorf("Length() after ApplyAbort = %d, want 0", e.Length())
+	}
+}
@@ -0,0 +1,163 @@
+package messaging
+
+import (
+	"context"
+	"sync"
+)
+
+// defaultTopicPartitionCapacity bounds how many recent messages each
+// topicPartitionBuffer retains in memory, regardless of how many
+// subscribers are reading it or how bursty publish gets.
+const defaultTopicPartitionCapacity = 100
+
+// bufferedMessage is a single decoded subscribeMessage retained in a
+// topicPartitionBuffer, keyed by its timetoken.
+type bufferedMessage struct {
+	Timetoken string
+	Data      []byte
+}
+
+// topicPartitionBuffer is a bounded ring of the most recent messages
+// published on a single channel/group name. Every TopicPartitionSubscription
+// on that name reads from this one buffer via its own cursor, instead of
+// each subscriber getting a private goroutine-copied channel.
+type topicPartitionBuffer struct {
+	sync.Mutex
+	cond     *sync.Cond
+	messages []bufferedMessage
+	capacity int
+	start    int    // ring index of the oldest retained message
+	count    int    // number of valid messages currently retained
+	head     uint64 // total number of messages ever appended
+}
+
+func newTopicPartitionBuffer(capacity int) *topicPartitionBuffer {
+	b := &topicPartitionBuffer{
+		messages: make([]bufferedMessage, capacity),
+		capacity: capacity,
+	}
+	b.cond = sync.NewCond(&b.Mutex)
+
+	return b
+}
+
+// append adds msg to the ring, overwriting the oldest entry once the
+// buffer is full, and wakes every subscriber blocked on this buffer.
+func (b *topicPartitionBuffer) append(msg bufferedMessage) {
+	b.Lock()
+	idx := (b.start + b.count) % b.capacity
+	b.messages[idx] = msg
+	if b.count == b.capacity {
+		b.start = (b.start + 1) % b.capacity
+	} else {
+		b.count++
+	}
+	b.head++
+	b.Unlock()
+
+	b.cond.Broadcast()
+}
+
+// oldestSeq returns the sequence number of the oldest message still
+// retained. Callers must hold the lock.
+func (b *topicPartitionBuffer) oldestSeq() uint64 {
+	return b.head - uint64(b.count)
+}
+
+// cursorFor computes the starting cursor for a new subscriber asking to
+// replay the last n messages (n <= 0 means start at the live head, i.e.
+// no replay).
+func (b *topicPartitionBuffer) cursorFor(replay int) uint64 {
+	b.Lock()
+	defer b.Unlock()
+
+	if replay <= 0 {
+		return b.head
+	}
+
+	oldest := b.oldestSeq()
+	if replay >= b.count {
+		return oldest
+	}
+	return b.head - uint64(replay)
+}
+
+// TopicPartitionSubscription is a single subscriber's read cursor over a
+// shared topicPartitionBuffer. Many subscriptions on the same channel
+// share one buffer; each tracks its own position independently and can
+// be canceled without affecting the others.
+type TopicPartitionSubscription struct {
+	ID     string
+	Name   string
+	buffer *topicPartitionBuffer
+	cursor uint64
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newTopicPartitionSubscription(ctx context.Context, id, name string,
+	buf *topicPartitionBuffer, cursor uint64) *TopicPartitionSubscription {
+
+	subCtx, cancel := context.WithCancel(ctx)
+
+	s := &TopicPartitionSubscription{
+		ID:     id,
+		Name:   name,
+		buffer: buf,
+		cursor: cursor,
+		ctx:    subCtx,
+		cancel: cancel,
+	}
+
+	// Wake every waiter on the shared buffer when this subscription is
+	// canceled, so its own Next() call can return promptly; other
+	// subscribers simply re-check their own context and go back to
+	// waiting, which is the expected cost of a shared sync.Cond.
+	go func() {
+		<-subCtx.Done()
+		buf.Lock()
+		buf.cond.Broadcast()
+		buf.Unlock()
+	}()
+
+	return s
+}
+
+// Done returns a channel that closes once the subscription is canceled.
+func (s *TopicPartitionSubscription) Done() <-chan struct{} {
+	return s.ctx.Done()
+}
+
+// Cancel stops the subscription; a blocked Next() call returns ok=false.
+func (s *TopicPartitionSubscription) Cancel() {
+	s.cancel()
+}
+
+// Next blocks until a new message is available, the subscription falls
+// too far behind the ring and must jump to the oldest retained message,
+// or the subscription is canceled (ok=false).
+func (s *TopicPartitionSubscription) Next() (msg bufferedMessage, ok bool) {
+	b := s.buffer
+
+	b.Lock()
+	defer b.Unlock()
+
+	for {
+		if s.ctx.Err() != nil {
+			return bufferedMessage{}, false
+		}
+
+		if oldest := b.oldestSeq(); s.cursor < oldest {
+			s.cursor = oldest
+		}
+
+		if s.cursor < b.head {
+			idx := (b.start + int(s.cursor-b.oldestSeq())) % b.capacity
+			msg = b.messages[idx]
+			s.cursor++
+			return msg, true
+		}
+
+		b.cond.Wait()
+	}
+}
@@ -0,0 +1,103 @@
+package messaging
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTopicPartitionBufferAppendAndCursorFor(t *testing.T) {
+	buf := newTopicPartitionBuffer(3)
+
+	// No messages yet: replay of any size starts at the live head.
+	if c := buf.cursorFor(5); c != 0 {
+		t.Errorf("cursorFor on empty buffer = %d, want 0", c)
+	}
+
+	for i := 0; i < 3; i++ {
+		buf.append(bufferedMessage{Timetoken: string(rune('a' + i))})
+	}
+
+	// Exactly as many messages as retained: replay the oldest.
+	if c := buf.cursorFor(3); c != 0 {
+		t.Errorf("cursorFor(3) with 3 retained = %d, want 0", c)
+	}
+
+	// Requesting more than retained clamps to the oldest retained.
+	if c := buf.cursorFor(10); c != 0 {
+		t.Errorf("cursorFor(10) with 3 retained = %d, want 0 (clamped)", c)
+	}
+
+	// Requesting fewer than retained starts that many back from head.
+	if c := buf.cursorFor(1); c != 2 {
+		t.Errorf("cursorFor(1) with head=3 = %d, want 2", c)
+	}
+
+	// replay<=0 means start at the live head, i.e. no replay.
+	if c := buf.cursorFor(0); c != 3 {
+		t.Errorf("cursorFor(0) = %d, want head (3)", c)
+	}
+
+	// Overwrite past capacity: the ring should evict the oldest message.
+	buf.append(bufferedMessage{Timetoken: "d"})
+	if buf.oldestSeq() != 1 {
+		t.Errorf("oldestSeq after wraparound = %d, want 1", buf.oldestSeq())
+	}
+	if c := buf.cursorFor(10); c != 1 {
+		t.Errorf("cursorFor(10) after wraparound = %d, want 1 (clamped to new oldest)", c)
+	}
+}
+
+func TestTopicPartitionSubscriptionNextWraparound(t *testing.T) {
+	buf := newTopicPartitionBuffer(2)
+
+	for _, tt := range []string{"a", "b"} {
+		buf.append(bufferedMessage{Timetoken: tt})
+	}
+
+	sub := newTopicPartitionSubscription(context.Background(), "id1", "name", buf, buf.cursorFor(2))
+	defer sub.Cancel()
+
+	msg, ok := sub.Next()
+	if !ok || msg.Timetoken != "a" {
+		t.Fatalf("first Next() = %+v, %v, want a, true", msg, ok)
+	}
+
+	// Overwrite both slots while the subscriber is behind: its cursor
+	// should jump forward to the oldest retained message rather than
+	// reading stale/overwritten ring slots.
+	buf.append(bufferedMessage{Timetoken: "c"})
+	buf.append(bufferedMessage{Timetoken: "d"})
+
+	msg, ok = sub.Next()
+	if !ok || msg.Timetoken != "c" {
+		t.Fatalf("Next() after falling behind = %+v, %v, want c, true (oldest retained)", msg, ok)
+	}
+
+	msg, ok = sub.Next()
+	if !ok || msg.Timetoken != "d" {
+		t.Fatalf("Next() = %+v, %v, want d, true", msg, ok)
+	}
+}
+
+func TestTopicPartitionSubscriptionNextCanceled(t *testing.T) {
+	buf := newTopicPartitionBuffer(2)
+	sub := newTopicPartitionSubscription(context.Background(), "id1", "name", buf, buf.cursorFor(0))
+
+	done := make(chan struct{})
+	go func() {
+		_, ok := sub.Next()
+		if ok {
+			t.Error("expected Next() to return ok=false once canceled")
+		}
+		close(done)
+	}()
+
+	sub.Cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Next() did not return after Cancel()")
+	}
+}